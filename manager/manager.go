@@ -1,136 +1,81 @@
+// Package manager is the agent-side counterpart to server/manager: it
+// dials out to a Lipstick server and keeps a session registered for a
+// domain, speaking the same mux-session protocol server/manager's /ws
+// endpoint expects (see server/egress) rather than the older
+// one-connection-per-request ticket handshake.
 package manager
 
 import (
-	"fmt"
-	"net"
+	"log"
 	"net/http"
+	"time"
 
-	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/juliotorresmoreno/lipstick/helper"
+	"github.com/juliotorresmoreno/lipstick/server/egress"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
-
-type wsChain struct {
-	Conn *websocket.Conn
-	err  error
-}
-
-type registerChain struct {
-	Conn *websocket.Conn
-	uuid string
-}
+// initialBackoff and maxBackoff bound the delay between reconnect
+// attempts: it starts at initialBackoff and doubles on every consecutive
+// failed dial, capped at maxBackoff, then resets once a dial succeeds.
+const (
+	initialBackoff = 1 * time.Second
+	maxBackoff     = 30 * time.Second
+)
 
-type Manager struct {
-	Pipe            chan net.Conn
-	engine          *gin.Engine
-	ws              *websocket.Conn
-	registerWs      chan *websocket.Conn
-	registerChain   chan *registerChain
-	unregisterChain chan string
-	pipes           map[string]net.Conn
-	channels        map[string]*websocket.Conn
-	wsChain         chan wsChain
+// Agent maintains a registered mux session with a Lipstick server,
+// reconnecting with exponential backoff whenever the connection drops.
+// Handler is called for each stream the server opens on the session —
+// typically dialing the local service being tunneled and piping bytes via
+// helper.Copy.
+type Agent struct {
+	TargetURL string
+	ProxyFlag string
+	Header    http.Header
+	Handler   func(stream *egress.Stream)
 }
 
-func SetupManager() *Manager {
-	r := gin.New()
-
-	manager := &Manager{
-		engine:          r,
-		pipes:           make(map[string]net.Conn),
-		channels:        make(map[string]*websocket.Conn),
-		wsChain:         make(chan wsChain),
-		registerWs:      make(chan *websocket.Conn),
-		registerChain:   make(chan *registerChain),
-		unregisterChain: make(chan string),
-		Pipe:            make(chan net.Conn),
-	}
+// Run connects to TargetURL and serves streams until the process exits. A
+// failed or dropped connection is retried with exponential backoff; Run
+// never returns, so it serves as the agent process's main loop.
+func (a *Agent) Run() {
+	backoff := initialBackoff
 
-	r.GET("/ws", func(c *gin.Context) {
-		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-		manager.wsChain <- wsChain{conn, err}
-	})
-
-	r.GET("/ws/:uuid", func(c *gin.Context) {
-		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	for {
+		conn, _, err := DialWebSocket(a.TargetURL, a.ProxyFlag, a.Header)
 		if err != nil {
-			fmt.Println(err)
-			return
-		}
-
-		uuid, ok := c.Params.Get("uuid")
-		if !ok {
-			return
+			log.Println("manager: dial failed, retrying in", backoff, ":", err)
+			time.Sleep(backoff)
+			backoff = nextBackoff(backoff)
+			continue
 		}
-		fmt.Println("connecting to", uuid)
-		manager.registerChain <- &registerChain{uuid: uuid, Conn: conn}
-	})
 
-	return manager
-}
-
-// Listening port
-func (manager *Manager) Listen(addr string) {
-	manager.engine.Run(addr)
+		backoff = initialBackoff
+		a.serve(conn)
+	}
 }
 
-// get ws con to manage
-func (manager *Manager) Accept() (*websocket.Conn, error) {
-	wsChain := <-manager.wsChain
+// serve wraps conn in a mux session and hands every stream the server
+// opens to Handler, returning once the session itself goes away so Run can
+// reconnect.
+func (a *Agent) serve(conn *websocket.Conn) {
+	session := egress.NewSession(helper.NewWebSocketIO(conn))
+	defer session.Close()
 
-	return wsChain.Conn, wsChain.err
-}
-
-// here you can accept new websocket client
-func (manager *Manager) Forward() {
 	for {
-		ws, err := manager.Accept()
+		stream, err := session.Accept()
 		if err != nil {
-			fmt.Println(err)
-			continue
+			log.Println("manager: session ended:", err)
+			return
 		}
-		fmt.Println("Client connect from", ws.RemoteAddr().String())
-		manager.registerWs <- ws
-
+		go a.Handler(stream)
 	}
 }
 
-func (manager *Manager) Manage() {
-	for {
-		select {
-		case ws := <-manager.registerWs:
-			manager.ws = ws
-		case channel := <-manager.registerChain:
-			manager.channels[channel.uuid] = channel.Conn
-
-			dest := helper.NewWebSocketIO(channel.Conn)
-			pipe := manager.pipes[channel.uuid]
-
-			go func() {
-				go helper.Copy(pipe, dest)
-
-				defer func() {
-					manager.unregisterChain <- channel.uuid
-				}()
-
-				helper.Copy(dest, pipe)
-			}()
-		case channel := <-manager.unregisterChain:
-			delete(manager.channels, channel)
-			delete(manager.pipes, channel)
-		case pipe := <-manager.Pipe:
-			ticket := uuid.NewString()
-			if ws := manager.ws; ws != nil {
-				ws.WriteJSON(map[string]string{"uuid": ticket})
-				manager.pipes[ticket] = pipe
-			}
-		}
+func nextBackoff(d time.Duration) time.Duration {
+	d *= 2
+	if d > maxBackoff {
+		return maxBackoff
 	}
+	return d
 }
@@ -0,0 +1,134 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// DialWebSocket connects to targetURL (ws:// or wss://) on behalf of the
+// agent, routing through an HTTP(S) proxy when one applies: proxyFlag
+// (wired up by the caller from a --proxy flag) always wins, otherwise
+// HTTPS_PROXY/HTTP_PROXY is consulted the way curl does. With no proxy
+// configured at all it falls back to a direct dial.
+func DialWebSocket(targetURL string, proxyFlag string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	target, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("manager: invalid target URL %q: %w", targetURL, err)
+	}
+
+	proxyURL, err := resolveProxyURL(target, proxyFlag)
+	if err != nil {
+		return nil, nil, err
+	}
+	if proxyURL == nil {
+		return websocket.DefaultDialer.Dial(targetURL, header)
+	}
+
+	dialer := &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialThroughProxy(ctx, proxyURL, addr)
+		},
+		NetDialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialThroughProxy(ctx, proxyURL, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: target.Hostname()})
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("manager: TLS handshake with %s via proxy %s: %w", addr, proxyURL.Host, err)
+			}
+			return tlsConn, nil
+		},
+	}
+
+	return dialer.Dial(targetURL, header)
+}
+
+// resolveProxyURL decides which proxy, if any, DialWebSocket should use to
+// reach target. An explicit flag value always wins; otherwise HTTPS_PROXY
+// is consulted for a wss:// target and HTTP_PROXY for ws://. A nil result
+// with no error means "dial target directly".
+func resolveProxyURL(target *url.URL, explicit string) (*url.URL, error) {
+	if explicit != "" {
+		proxyURL, err := url.Parse(explicit)
+		if err != nil {
+			return nil, fmt.Errorf("manager: invalid --proxy value %q: %w", explicit, err)
+		}
+		return proxyURL, nil
+	}
+
+	envVar := "HTTP_PROXY"
+	if target.Scheme == "wss" {
+		envVar = "HTTPS_PROXY"
+	}
+
+	raw := os.Getenv(envVar)
+	if raw == "" {
+		raw = os.Getenv(strings.ToLower(envVar))
+	}
+	if raw == "" {
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("manager: invalid %s value %q: %w", envVar, raw, err)
+	}
+	return proxyURL, nil
+}
+
+// dialThroughProxy opens a TCP connection to proxyURL and asks it, via
+// HTTP CONNECT, to tunnel to addr. Basic auth credentials carried in
+// proxyURL's userinfo are sent automatically. A non-200 response is
+// reported with the proxy's own host so a misconfigured proxy is
+// diagnosable from the error alone.
+func dialThroughProxy(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("manager: dialing proxy %s: %w", proxyURL.Host, err)
+	}
+
+	connectReq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		connectReq.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+
+	if err := connectReq.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("manager: sending CONNECT to proxy %s: %w", proxyURL.Host, err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), connectReq)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("manager: reading CONNECT response from proxy %s: %w", proxyURL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("manager: proxy %s refused CONNECT to %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+
+	return conn, nil
+}
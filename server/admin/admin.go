@@ -0,0 +1,149 @@
+// Package admin exposes a runtime control API for a running Manager:
+// listing registered domains and their hubs, inspecting traffic counters,
+// disconnecting an agent, reloading configuration, and a Prometheus
+// /metrics endpoint. It's bound to its own address, separate from the
+// public proxy and the agent-facing manager port, and every request must
+// carry the configured admin bearer token. This is deliberately a separate
+// credential from manager.AuthManager(), which authorizes domains rather
+// than operator access.
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juliotorresmoreno/lipstick/server/auth"
+	"github.com/juliotorresmoreno/lipstick/server/config"
+	"github.com/juliotorresmoreno/lipstick/server/manager"
+)
+
+// Server runs the admin HTTP API.
+type Server struct {
+	engine  *gin.Engine
+	manager *manager.Manager
+	addr    string
+	token   string
+}
+
+// NewServer builds an admin Server for mgr, bound to addr. Every request
+// must carry "Authorization: Bearer <token>".
+func NewServer(mgr *manager.Manager, addr string, token string) *Server {
+	gin.SetMode(gin.ReleaseMode)
+
+	server := &Server{engine: gin.New(), manager: mgr, addr: addr, token: token}
+	server.engine.Use(server.authenticate)
+
+	api := server.engine.Group("/api")
+	api.GET("/hubs", server.listHubs)
+	api.GET("/hubs/:domain/connections", server.hubConnections)
+	api.DELETE("/hubs/:domain", server.disconnectHub)
+	api.PUT("/config", server.reloadConfig)
+
+	server.engine.GET("/metrics", server.metrics)
+
+	return server
+}
+
+// Listen blocks serving the admin API on addr.
+func (server *Server) Listen() error {
+	return server.engine.Run(server.addr)
+}
+
+func (server *Server) authenticate(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if server.token == "" || token != server.token {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	c.Next()
+}
+
+type hubView struct {
+	Domain     string `json:"domain"`
+	AgentCount int    `json:"agentCount"`
+	Pending    int    `json:"pending"`
+}
+
+func (server *Server) listHubs(c *gin.Context) {
+	snapshots := server.manager.HubsSnapshot()
+	views := make([]hubView, 0, len(snapshots))
+	for _, s := range snapshots {
+		views = append(views, hubView{Domain: s.Domain, AgentCount: s.AgentCount, Pending: s.Pending})
+	}
+	c.JSON(http.StatusOK, views)
+}
+
+type connectionsView struct {
+	Domain   string `json:"domain"`
+	BytesIn  int64  `json:"bytesIn"`
+	BytesOut int64  `json:"bytesOut"`
+}
+
+func (server *Server) hubConnections(c *gin.Context) {
+	domain := c.Param("domain")
+	counter := server.manager.TrafficManager().Snapshot(domain)
+	c.JSON(http.StatusOK, connectionsView{
+		Domain:   domain,
+		BytesIn:  counter.BytesIn,
+		BytesOut: counter.BytesOut,
+	})
+}
+
+func (server *Server) disconnectHub(c *gin.Context) {
+	domain := c.Param("domain")
+	if !server.manager.DisconnectDomain(domain) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// reloadConfig re-reads the YAML config file and reconciles its auth
+// settings into the running Manager, so a changed domain/token list takes
+// effect without restarting the process. It does not touch manager.addr,
+// manager.cert or manager.key: those back an already-bound listener and
+// would need it rebuilt, not just swapped, to change live.
+func (server *Server) reloadConfig(c *gin.Context) {
+	cfg, err := config.Reload()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	authManager, err := auth.NewBackend(cfg)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	server.manager.SetAuthManager(authManager)
+
+	c.Status(http.StatusNoContent)
+}
+
+func (server *Server) metrics(c *gin.Context) {
+	snapshots := server.manager.HubsSnapshot()
+	counters := server.manager.TrafficManager().All()
+
+	var body strings.Builder
+
+	fmt.Fprint(&body, "# HELP lipstick_hubs Number of registered domains\n")
+	fmt.Fprint(&body, "# TYPE lipstick_hubs gauge\n")
+	fmt.Fprintf(&body, "lipstick_hubs %d\n", len(snapshots))
+
+	fmt.Fprint(&body, "# HELP lipstick_hub_agents Agents connected per domain\n")
+	fmt.Fprint(&body, "# TYPE lipstick_hub_agents gauge\n")
+	for _, s := range snapshots {
+		fmt.Fprintf(&body, "lipstick_hub_agents{domain=%q} %d\n", s.Domain, s.AgentCount)
+	}
+
+	fmt.Fprint(&body, "# HELP lipstick_bytes_total Bytes transferred per domain\n")
+	fmt.Fprint(&body, "# TYPE lipstick_bytes_total counter\n")
+	for domain, counter := range counters {
+		fmt.Fprintf(&body, "lipstick_bytes_total{domain=%q,direction=\"in\"} %d\n", domain, counter.BytesIn)
+		fmt.Fprintf(&body, "lipstick_bytes_total{domain=%q,direction=\"out\"} %d\n", domain, counter.BytesOut)
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(body.String()))
+}
@@ -0,0 +1,73 @@
+// Package auth authenticates domain registrations. AuthManager is an
+// interface so the manager can be configured with whichever backend fits
+// the deployment — see file.go, http.go, and oidc.go.
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Identity is the result of a successful authentication: which domain
+// patterns the caller is allowed to claim.
+type Identity struct {
+	Subject string
+	Domains []string
+}
+
+// Allowed reports whether domain matches one of Identity's domain
+// patterns. A pattern of "*.example.com" matches any direct subdomain of
+// example.com.
+func (id Identity) Allowed(domain string) bool {
+	for _, pattern := range id.Domains {
+		if pattern == domain {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(domain, pattern[1:]) {
+			label := strings.TrimSuffix(domain, pattern[1:])
+			if label != "" && !strings.Contains(label, ".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AuthManager authenticates an incoming domain registration request and
+// reports the identity behind it. The WebSocket upgrade handler rejects
+// any registration whose claimed domain isn't allowed by that identity,
+// replacing the previous implicit "any WebSocket wins" behavior.
+type AuthManager interface {
+	Authenticate(ctx context.Context, r *http.Request) (Identity, error)
+}
+
+// KeyAuthorizer is implemented by AuthManager backends that can also
+// authorize raw SSH public keys per domain, for the SSH reverse-tunnel
+// path (see server/ssh). Only FileBackend supports this today.
+type KeyAuthorizer interface {
+	AuthorizedKey(domain string, key ssh.PublicKey) bool
+}
+
+type authError struct{ msg string }
+
+func (e *authError) Error() string { return e.msg }
+
+// ErrUnauthenticated is returned when the request carries no usable
+// credentials at all.
+var ErrUnauthenticated = &authError{"auth: no credentials presented"}
+
+// ErrUnauthorized is returned when credentials were presented but rejected.
+var ErrUnauthorized = &authError{"auth: credentials rejected"}
+
+// bearerToken extracts a bearer token from the Authorization header, or
+// failing that the Sec-WebSocket-Protocol header — the only header
+// browsers and most WebSocket client libraries let a caller set freely.
+func bearerToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return r.Header.Get("Sec-WebSocket-Protocol")
+}
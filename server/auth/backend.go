@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/juliotorresmoreno/lipstick/server/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultHTTPTTL is used when AuthBackendConfig.HTTP.TTLSeconds is left
+// unset (zero), so a config that only sets Backend/URL still caches.
+const defaultHTTPTTL = 30 * time.Second
+
+// NewBackend builds the AuthManager cfg.AuthBackend selects: "file" (the
+// default) wraps cfg.Manager.Auth's token list, "http" builds an
+// HTTPBackend, and "oidc" builds an OIDCBackend. cfg.AuthBackend.SSHKeys is
+// only applied for "file": FileBackend is the only backend implementing
+// KeyAuthorizer, so with "http" or "oidc" the keys are ignored and the SSH
+// reverse-tunnel path rejects every forward (see server/ssh).
+func NewBackend(cfg config.Config) (AuthManager, error) {
+	switch cfg.AuthBackend.Backend {
+	case "", "file":
+		backend := NewFileBackend(cfg.Manager.Auth)
+		if err := allowSSHKeys(backend, cfg.AuthBackend.SSHKeys); err != nil {
+			return nil, err
+		}
+		return backend, nil
+
+	case "http":
+		if cfg.AuthBackend.HTTP.URL == "" {
+			return nil, fmt.Errorf("auth: http backend requires authBackend.http.url")
+		}
+		ttl := time.Duration(cfg.AuthBackend.HTTP.TTLSeconds) * time.Second
+		if ttl <= 0 {
+			ttl = defaultHTTPTTL
+		}
+		return NewHTTPBackend(cfg.AuthBackend.HTTP.URL, ttl), nil
+
+	case "oidc":
+		o := cfg.AuthBackend.OIDC
+		if o.Issuer == "" || o.ClientID == "" || o.DomainClaim == "" {
+			return nil, fmt.Errorf("auth: oidc backend requires authBackend.oidc.issuer, clientId, and domainClaim")
+		}
+		return NewOIDCBackend(context.Background(), o.Issuer, o.ClientID, o.DomainClaim)
+
+	default:
+		return nil, fmt.Errorf("auth: unknown authBackend.backend %q", cfg.AuthBackend.Backend)
+	}
+}
+
+// allowSSHKeys parses each configured authorized_keys-format entry and
+// registers it with backend.
+func allowSSHKeys(backend *FileBackend, entries []config.SSHAuthorizedKey) error {
+	for _, entry := range entries {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(entry.Key))
+		if err != nil {
+			return fmt.Errorf("auth: parsing ssh key for domain %s: %w", entry.Domain, err)
+		}
+		backend.AllowKey(entry.Domain, key)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/juliotorresmoreno/lipstick/server/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// FileBackend authenticates against a static token -> domains map loaded
+// from YAML (Config.Manager.Auth), and backs the SSH reverse-tunnel path
+// with a domain -> authorized-keys map.
+type FileBackend struct {
+	mu             sync.RWMutex
+	domainsByToken map[string][]string
+	authorizedKeys map[string][]ssh.PublicKey
+}
+
+// NewFileBackend builds a FileBackend from domain/token pairs such as
+// Config.Manager.Auth. Multiple entries may share a token to let one agent
+// claim several domains.
+func NewFileBackend(entries []config.DomainAuth) *FileBackend {
+	backend := &FileBackend{
+		domainsByToken: make(map[string][]string),
+		authorizedKeys: make(map[string][]ssh.PublicKey),
+	}
+	for _, entry := range entries {
+		backend.domainsByToken[entry.Token] = append(backend.domainsByToken[entry.Token], entry.Domain)
+	}
+	return backend
+}
+
+// AllowKey registers key as authorized to claim domain over SSH.
+func (b *FileBackend) AllowKey(domain string, key ssh.PublicKey) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.authorizedKeys[domain] = append(b.authorizedKeys[domain], key)
+}
+
+// AuthorizedKey implements KeyAuthorizer.
+func (b *FileBackend) AuthorizedKey(domain string, key ssh.PublicKey) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, k := range b.authorizedKeys[domain] {
+		if ssh.KeysEqual(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate implements AuthManager by matching the bearer token on the
+// request against the configured domain map.
+func (b *FileBackend) Authenticate(ctx context.Context, r *http.Request) (Identity, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return Identity{}, ErrUnauthenticated
+	}
+
+	b.mu.RLock()
+	domains, ok := b.domainsByToken[token]
+	b.mu.RUnlock()
+	if !ok {
+		return Identity{}, ErrUnauthorized
+	}
+
+	return Identity{Subject: token, Domains: domains}, nil
+}
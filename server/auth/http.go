@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPBackend authenticates by POSTing the presented ticket, the claimed
+// domain, and the caller's source IP to a configured URL, treating a 2xx
+// response as success. Successful checks are cached for ttl so a busy
+// agent reconnecting doesn't hammer the upstream on every request.
+type HTTPBackend struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedIdentity
+}
+
+type cachedIdentity struct {
+	identity Identity
+	expires  time.Time
+}
+
+// NewHTTPBackend builds an HTTPBackend that POSTs to checkURL and caches
+// successful results for ttl.
+func NewHTTPBackend(checkURL string, ttl time.Duration) *HTTPBackend {
+	return &HTTPBackend{
+		url:    checkURL,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 5 * time.Second},
+		cache:  make(map[string]cachedIdentity),
+	}
+}
+
+// Authenticate implements AuthManager.
+func (b *HTTPBackend) Authenticate(ctx context.Context, r *http.Request) (Identity, error) {
+	ticket := bearerToken(r)
+	if ticket == "" {
+		return Identity{}, ErrUnauthenticated
+	}
+	domain := r.URL.Query().Get("domain")
+
+	cacheKey := ticket + "|" + domain
+	if identity, ok := b.cached(cacheKey); ok {
+		return identity, nil
+	}
+
+	form := url.Values{
+		"ticket": {ticket},
+		"domain": {domain},
+		"ip":     {clientIP(r)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return Identity{}, ErrUnauthorized
+	}
+
+	identity := Identity{Subject: ticket, Domains: []string{domain}}
+	b.remember(cacheKey, identity)
+	return identity, nil
+}
+
+func (b *HTTPBackend) cached(key string) (Identity, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, ok := b.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return Identity{}, false
+	}
+	return entry.identity, true
+}
+
+func (b *HTTPBackend) remember(key string, identity Identity) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cache[key] = cachedIdentity{identity: identity, expires: time.Now().Add(b.ttl)}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coreos/go-oidc"
+)
+
+// OIDCBackend validates a JWT bearer token against an OIDC issuer's JWKS,
+// extracting the domains a token may claim from a configurable claim.
+type OIDCBackend struct {
+	verifier    *oidc.IDTokenVerifier
+	domainClaim string
+}
+
+// NewOIDCBackend builds an OIDCBackend. issuer is the OIDC issuer URL,
+// clientID the expected audience, and domainClaim the token claim holding
+// the domains (or domain patterns, e.g. "*.example.com") the bearer may
+// claim.
+func NewOIDCBackend(ctx context.Context, issuer, clientID, domainClaim string) (*OIDCBackend, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("auth: discover oidc provider %s: %w", issuer, err)
+	}
+
+	return &OIDCBackend{
+		verifier:    provider.Verifier(&oidc.Config{ClientID: clientID}),
+		domainClaim: domainClaim,
+	}, nil
+}
+
+// Authenticate implements AuthManager.
+func (b *OIDCBackend) Authenticate(ctx context.Context, r *http.Request) (Identity, error) {
+	rawToken := bearerToken(r)
+	if rawToken == "" {
+		return Identity{}, ErrUnauthenticated
+	}
+
+	idToken, err := b.verifier.Verify(ctx, rawToken)
+	if err != nil {
+		return Identity{}, fmt.Errorf("auth: verify token: %w", err)
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, fmt.Errorf("auth: read claims: %w", err)
+	}
+
+	domains := domainsFromClaim(claims[b.domainClaim])
+	if len(domains) == 0 {
+		return Identity{}, ErrUnauthorized
+	}
+
+	return Identity{Subject: idToken.Subject, Domains: domains}, nil
+}
+
+func domainsFromClaim(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		domains := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				domains = append(domains, s)
+			}
+		}
+		return domains
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,11 @@
+package common
+
+import "net"
+
+// RemoteConn is a public connection accepted by the proxy listener, tagged
+// with the domain it was routed for so the manager can hand it to the right
+// hub without re-parsing the request.
+type RemoteConn struct {
+	net.Conn
+	Domain string
+}
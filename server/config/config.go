@@ -1,75 +1,315 @@
 package config
 
 import (
+	"bytes"
 	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/juliotorresmoreno/lipstick/helper"
 	"gopkg.in/yaml.v3"
 )
 
+// DomainAuth binds a single domain to the token an agent must present to
+// claim it.
+type DomainAuth struct {
+	Domain string `yaml:"domain"`
+	Token  string `yaml:"token"`
+}
+
 type Config struct {
 	Proxy struct {
 		Addr string `yaml:"addr"`
 	} `yaml:"proxy"`
 	Manager struct {
-		Addr string `yaml:"addr"`
+		Addr string       `yaml:"addr"`
+		Cert string       `yaml:"cert"`
+		Key  string       `yaml:"key"`
+		Auth []DomainAuth `yaml:"auth"`
 	} `yaml:"manager"`
+	AuthBackend AuthBackendConfig `yaml:"authBackend"`
+}
+
+// SSHAuthorizedKey authorizes one SSH public key, in authorized_keys
+// format, to claim Domain over the SSH reverse-tunnel path (see
+// server/ssh). It's independent of Manager.Auth's token list, which
+// authorizes the WebSocket path instead.
+type SSHAuthorizedKey struct {
+	Domain string `yaml:"domain"`
+	Key    string `yaml:"key"`
 }
 
+// HTTPAuthConfig configures the "http" AuthBackendConfig.Backend: see
+// server/auth's HTTPBackend.
+type HTTPAuthConfig struct {
+	URL        string `yaml:"url"`
+	TTLSeconds int    `yaml:"ttlSeconds"`
+}
+
+// OIDCAuthConfig configures the "oidc" AuthBackendConfig.Backend: see
+// server/auth's OIDCBackend.
+type OIDCAuthConfig struct {
+	Issuer      string `yaml:"issuer"`
+	ClientID    string `yaml:"clientId"`
+	DomainClaim string `yaml:"domainClaim"`
+}
+
+// AuthBackendConfig selects which server/auth backend the manager
+// authenticates domain registrations against. Backend defaults to "file",
+// keeping Manager.Auth's token list as the whole auth story for
+// deployments that don't need an external check. SSHKeys is only used when
+// Backend is "file", the only backend that implements auth.KeyAuthorizer,
+// which the SSH reverse-tunnel path needs; other backends ignore it.
+type AuthBackendConfig struct {
+	Backend string             `yaml:"backend"`
+	HTTP    HTTPAuthConfig     `yaml:"http"`
+	OIDC    OIDCAuthConfig     `yaml:"oidc"`
+	SSHKeys []SSHAuthorizedKey `yaml:"sshKeys"`
+}
+
+// ValidationError reports a single config field that failed strict
+// validation.
+type ValidationError struct {
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("config: %s: %s", e.Field, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// configMu guards config and configErr: Reload lets PUT /api/config (see
+// server/admin) re-read the file from a gin handler goroutine, racing any
+// concurrent GetConfig caller.
+var configMu sync.RWMutex
 var config interface{}
+var configErr error
+
+var (
+	flagsOnce sync.Once
+
+	configPath  string
+	managerAddr string
+	proxyAddr   string
+	strictMode  bool
+)
 
+// parseFlags registers and parses the config-related CLI flags exactly
+// once, so Reload can re-read the config file without panicking on
+// already-registered flags.
+func parseFlags() {
+	flagsOnce.Do(func() {
+		dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		configPathDefault := path.Join(dir, "config.client.yml")
+		flag.StringVar(&configPath, "c", configPathDefault, "config path")
+		flag.StringVar(&managerAddr, "m", ":8081", "Port where your client will connect via websocket. You can manage it in your firewall")
+		flag.StringVar(&proxyAddr, "p", ":8080", "Port where you will get all requests from local network or internet")
+		flag.BoolVar(&strictMode, "strict-config", false, "fail on unknown YAML fields and missing required settings instead of falling back to defaults")
+
+		flag.Parse()
+	})
+}
+
+// loadConfig reads and validates the config file and stores the result in
+// config/configErr under configMu. Callers must already have checked
+// config == nil under configMu's read lock, the way GetConfig does.
 func loadConfig() {
-	var configPath = ""
-	var managerAddr = ""
-	var proxyAddr = ""
+	result, err := readConfig()
 
-	result := Config{}
-	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	configMu.Lock()
+	defer configMu.Unlock()
 	if err != nil {
-		log.Fatal(err)
+		configErr = err
+		return
 	}
+	config = result
+}
 
-	configPathDefault := path.Join(dir, "config.client.yml")
-	flag.StringVar(&configPath, "c", configPathDefault, "config path")
-	flag.StringVar(&managerAddr, "m", ":8081", "Port where your client will connect via websocket. You can manage it in your firewall")
-	flag.StringVar(&proxyAddr, "p", ":8080", "Port where you will get all requests from local network or internet")
+// readConfig builds a Config from the config file and CLI flags without
+// touching the package-level config/configErr, so loadConfig can do that
+// under a single lock instead of racing readers across several early
+// returns.
+func readConfig() (Config, error) {
+	parseFlags()
+	strict := strictMode
 
-	flag.Parse()
+	result := Config{}
 
 	f, err := os.Open(configPath)
 	if err == nil {
-		buff, err := io.ReadAll(f)
-		if err != nil {
-			return
-		}
-		err = yaml.Unmarshal(buff, &result)
-		if err != nil {
-			return
+		defer f.Close()
+		buff, readErr := io.ReadAll(f)
+		if readErr != nil {
+			if strict {
+				return Config{}, fmt.Errorf("config: reading %s: %w", configPath, readErr)
+			}
+		} else if decodeErr := decodeYAML(buff, &result); decodeErr != nil {
+			if strict {
+				return Config{}, decodeErr
+			}
+			log.Println("config: warning:", decodeErr)
 		}
+	} else if strict {
+		return Config{}, fmt.Errorf("config: opening %s: %w", configPath, err)
 	}
 
 	result.Proxy.Addr = helper.SetValue(proxyAddr, result.Proxy.Addr).(string)
 	result.Manager.Addr = helper.SetValue(managerAddr, result.Manager.Addr).(string)
 
-	config = result
+	if strict {
+		if err := validate(&result); err != nil {
+			return Config{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// decodeYAML decodes buff into result, rejecting unknown fields so strict
+// mode can catch them; in non-strict mode readConfig downgrades any
+// resulting error, unknown-field or otherwise, to a logged warning. On an
+// unknown-field error every known field has still been decoded and is
+// kept; only what failed to decode stays at its default.
+func decodeYAML(buff []byte, result *Config) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(buff))
+	decoder.KnownFields(true)
+	return decoder.Decode(result)
+}
+
+// validate enforces the settings --strict-config requires: the addresses
+// Lipstick needs to bind, well-formed host:port pairs, cert/key files that
+// actually exist if configured, and syntactically valid domain patterns.
+func validate(cfg *Config) error {
+	if cfg.Proxy.Addr == "" {
+		return &ValidationError{Field: "proxy.addr", Err: errors.New("is required")}
+	}
+	if _, _, err := net.SplitHostPort(cfg.Proxy.Addr); err != nil {
+		return &ValidationError{Field: "proxy.addr", Err: err}
+	}
+
+	if cfg.Manager.Addr == "" {
+		return &ValidationError{Field: "manager.addr", Err: errors.New("is required")}
+	}
+	if _, _, err := net.SplitHostPort(cfg.Manager.Addr); err != nil {
+		return &ValidationError{Field: "manager.addr", Err: err}
+	}
+
+	if (cfg.Manager.Cert == "") != (cfg.Manager.Key == "") {
+		return &ValidationError{Field: "manager.cert/manager.key", Err: errors.New("cert and key must be set together")}
+	}
+	if cfg.Manager.Cert != "" {
+		if _, err := os.Stat(cfg.Manager.Cert); err != nil {
+			return &ValidationError{Field: "manager.cert", Err: err}
+		}
+	}
+	if cfg.Manager.Key != "" {
+		if _, err := os.Stat(cfg.Manager.Key); err != nil {
+			return &ValidationError{Field: "manager.key", Err: err}
+		}
+	}
+
+	for i, a := range cfg.Manager.Auth {
+		field := fmt.Sprintf("manager.auth[%d].domain", i)
+		if a.Domain == "" {
+			return &ValidationError{Field: field, Err: errors.New("is required")}
+		}
+		if !validDomainPattern(a.Domain) {
+			return &ValidationError{Field: field, Err: errors.New("is not a valid domain pattern")}
+		}
+	}
+
+	switch cfg.AuthBackend.Backend {
+	case "", "file":
+	case "http":
+		if cfg.AuthBackend.HTTP.URL == "" {
+			return &ValidationError{Field: "authBackend.http.url", Err: errors.New(`is required when authBackend.backend is "http"`)}
+		}
+	case "oidc":
+		if cfg.AuthBackend.OIDC.Issuer == "" {
+			return &ValidationError{Field: "authBackend.oidc.issuer", Err: errors.New(`is required when authBackend.backend is "oidc"`)}
+		}
+		if cfg.AuthBackend.OIDC.ClientID == "" {
+			return &ValidationError{Field: "authBackend.oidc.clientId", Err: errors.New(`is required when authBackend.backend is "oidc"`)}
+		}
+		if cfg.AuthBackend.OIDC.DomainClaim == "" {
+			return &ValidationError{Field: "authBackend.oidc.domainClaim", Err: errors.New(`is required when authBackend.backend is "oidc"`)}
+		}
+	default:
+		return &ValidationError{Field: "authBackend.backend", Err: fmt.Errorf("must be %q, %q, or %q, got %q", "file", "http", "oidc", cfg.AuthBackend.Backend)}
+	}
+
+	for i, k := range cfg.AuthBackend.SSHKeys {
+		field := fmt.Sprintf("authBackend.sshKeys[%d]", i)
+		if k.Domain == "" {
+			return &ValidationError{Field: field + ".domain", Err: errors.New("is required")}
+		}
+		if k.Key == "" {
+			return &ValidationError{Field: field + ".key", Err: errors.New("is required")}
+		}
+	}
+
+	return nil
+}
+
+// validDomainPattern accepts a plain domain or a single leading wildcard
+// label ("*.example.com"), with no empty labels.
+func validDomainPattern(domain string) bool {
+	domain = strings.TrimPrefix(domain, "*.")
+	if domain == "" {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || strings.Contains(label, "*") {
+			return false
+		}
+	}
+	return true
 }
 
 func GetConfig() (Config, error) {
-	if config != nil {
-		return config.(Config), nil
+	configMu.RLock()
+	cached := config
+	configMu.RUnlock()
+	if cached != nil {
+		return cached.(Config), nil
 	}
 
 	loadConfig()
 
+	configMu.RLock()
+	defer configMu.RUnlock()
+	if configErr != nil {
+		return Config{}, configErr
+	}
 	if config == nil {
-		log.Fatal(errors.New("could not load config"))
+		return Config{}, errors.New("config: could not load config")
 	}
 
 	return config.(Config), nil
 }
+
+// Reload re-reads and re-validates the config file from disk, discarding
+// any cached value. Callers like the admin API's PUT /api/config use this
+// to pick up changes without restarting.
+func Reload() (Config, error) {
+	configMu.Lock()
+	config = nil
+	configErr = nil
+	configMu.Unlock()
+
+	return GetConfig()
+}
@@ -0,0 +1,69 @@
+package egress
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type frameType byte
+
+const (
+	frameOpen  frameType = 1
+	frameData  frameType = 2
+	frameClose frameType = 3
+	framePing  frameType = 4
+	framePong  frameType = 5
+)
+
+const frameHeaderSize = 1 + 4 + 4 // type + stream id + payload length
+
+// maxFramePayload bounds a single frame's payload so a corrupt or hostile
+// peer can't make readFrame allocate an unbounded buffer.
+const maxFramePayload = 1 << 20 // 1 MiB
+
+type frame struct {
+	typ      frameType
+	streamID uint32
+	payload  []byte
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	header := make([]byte, frameHeaderSize)
+	header[0] = byte(f.typ)
+	binary.BigEndian.PutUint32(header[1:5], f.streamID)
+	binary.BigEndian.PutUint32(header[5:9], uint32(len(f.payload)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if len(f.payload) == 0 {
+		return nil
+	}
+	_, err := w.Write(f.payload)
+	return err
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return frame{}, err
+	}
+
+	length := binary.BigEndian.Uint32(header[5:9])
+	if length > maxFramePayload {
+		return frame{}, fmt.Errorf("egress: frame payload too large (%d bytes)", length)
+	}
+
+	payload := make([]byte, length)
+	if length > 0 {
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return frame{}, err
+		}
+	}
+
+	return frame{
+		typ:      frameType(header[0]),
+		streamID: binary.BigEndian.Uint32(header[1:5]),
+		payload:  payload,
+	}, nil
+}
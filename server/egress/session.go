@@ -0,0 +1,174 @@
+// Package egress multiplexes many logical byte streams over a single
+// WebSocket connection with a small length-prefixed frame protocol, so one
+// egress agent can serve many concurrent CONNECT/SOCKS5 requests (see
+// server/proxy) without opening a new WebSocket per request.
+package egress
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Session owns one multiplexed connection. The manager opens a Stream per
+// outbound request (Open); the agent process accepts each one (Accept) and
+// learns its target from Stream.Target.
+type Session struct {
+	conn    io.ReadWriteCloser
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint32
+	streams map[uint32]*Stream
+	accept  chan *Stream
+	closed  chan struct{}
+	once    sync.Once
+	pong    chan struct{}
+}
+
+// NewSession wraps conn, typically a WebSocket connection adapted via
+// helper.NewWebSocketIO, and starts reading frames from it in the
+// background.
+func NewSession(conn io.ReadWriteCloser) *Session {
+	session := &Session{
+		conn:    conn,
+		streams: make(map[uint32]*Stream),
+		accept:  make(chan *Stream, 8),
+		closed:  make(chan struct{}),
+		pong:    make(chan struct{}, 1),
+	}
+	go session.readLoop()
+	return session
+}
+
+// Open starts a new stream addressed to target (a "host:port", or any other
+// string such as a JSON request header) and returns it immediately; the
+// remote side learns the target once it Accepts.
+func (s *Session) Open(target string) (*Stream, error) {
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	stream := newStream(s, id)
+	stream.target = target
+	s.streams[id] = stream
+	s.mu.Unlock()
+
+	if err := s.writeFrame(frame{typ: frameOpen, streamID: id, payload: []byte(target)}); err != nil {
+		s.mu.Lock()
+		delete(s.streams, id)
+		s.mu.Unlock()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Ping sends a control frame the remote side is expected to echo straight
+// back, and waits up to timeout for that reply. It catches a session that's
+// gone half-open — dead without either side's read ever failing, e.g.
+// behind a silent network partition — which the hub relies on to evict the
+// session instead of leastLoaded steering requests at it forever.
+func (s *Session) Ping(timeout time.Duration) error {
+	if err := s.writeFrame(frame{typ: framePing}); err != nil {
+		return err
+	}
+
+	select {
+	case <-s.pong:
+		return nil
+	case <-s.closed:
+		return fmt.Errorf("egress: session closed")
+	case <-time.After(timeout):
+		return fmt.Errorf("egress: ping timed out")
+	}
+}
+
+// Done returns a channel that's closed once the session has shut down, so
+// callers can stop waiting on it without polling Close's effects.
+func (s *Session) Done() <-chan struct{} {
+	return s.closed
+}
+
+// Accept blocks until a remote Open arrives and returns the resulting
+// stream, or an error once the session is closed.
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case stream, ok := <-s.accept:
+		if !ok {
+			return nil, fmt.Errorf("egress: session closed")
+		}
+		return stream, nil
+	case <-s.closed:
+		return nil, fmt.Errorf("egress: session closed")
+	}
+}
+
+func (s *Session) writeFrame(f frame) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return writeFrame(s.conn, f)
+}
+
+func (s *Session) readLoop() {
+	defer s.Close()
+	for {
+		f, err := readFrame(s.conn)
+		if err != nil {
+			return
+		}
+
+		switch f.typ {
+		case frameOpen:
+			s.mu.Lock()
+			stream := newStream(s, f.streamID)
+			stream.target = string(f.payload)
+			s.streams[f.streamID] = stream
+			s.mu.Unlock()
+
+			select {
+			case s.accept <- stream:
+			case <-s.closed:
+				return
+			}
+		case frameData:
+			s.mu.Lock()
+			stream := s.streams[f.streamID]
+			s.mu.Unlock()
+			if stream != nil {
+				stream.deliver(f.payload)
+			}
+		case frameClose:
+			s.mu.Lock()
+			stream := s.streams[f.streamID]
+			delete(s.streams, f.streamID)
+			s.mu.Unlock()
+			if stream != nil {
+				stream.deliverEOF()
+			}
+		case framePing:
+			_ = s.writeFrame(frame{typ: framePong})
+		case framePong:
+			select {
+			case s.pong <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Close tears down every open stream and the underlying connection. Safe
+// to call more than once.
+func (s *Session) Close() error {
+	s.once.Do(func() {
+		s.mu.Lock()
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		s.mu.Unlock()
+
+		for _, stream := range streams {
+			stream.deliverEOF()
+		}
+		close(s.closed)
+	})
+	return s.conn.Close()
+}
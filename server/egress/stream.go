@@ -0,0 +1,90 @@
+package egress
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream is one logical byte connection multiplexed over a Session. It
+// implements net.Conn so it can be handed straight into helper.Copy
+// alongside ordinary TCP connections.
+type Stream struct {
+	session *Session
+	id      uint32
+	target  string
+
+	buf     []byte
+	readCh  chan []byte
+	eof     chan struct{}
+	eofOnce sync.Once
+}
+
+func newStream(session *Session, id uint32) *Stream {
+	return &Stream{
+		session: session,
+		id:      id,
+		readCh:  make(chan []byte, 16),
+		eof:     make(chan struct{}),
+	}
+}
+
+// Target is the payload the stream was opened with: a host:port for an
+// egress stream, or the JSON request header a NetworkHub sends for a
+// domain stream.
+func (s *Stream) Target() string { return s.target }
+
+func (s *Stream) deliver(payload []byte) {
+	select {
+	case s.readCh <- payload:
+	case <-s.eof:
+	}
+}
+
+func (s *Stream) deliverEOF() {
+	s.eofOnce.Do(func() { close(s.eof) })
+}
+
+func (s *Stream) Read(p []byte) (int, error) {
+	if len(s.buf) == 0 {
+		select {
+		case chunk, ok := <-s.readCh:
+			if !ok {
+				return 0, io.EOF
+			}
+			s.buf = chunk
+		case <-s.eof:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *Stream) Write(p []byte) (int, error) {
+	if err := s.session.writeFrame(frame{typ: frameData, streamID: s.id, payload: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *Stream) Close() error {
+	s.deliverEOF()
+	s.session.mu.Lock()
+	delete(s.session.streams, s.id)
+	s.session.mu.Unlock()
+	return s.session.writeFrame(frame{typ: frameClose, streamID: s.id})
+}
+
+func (s *Stream) LocalAddr() net.Addr                { return streamAddr(s.target) }
+func (s *Stream) RemoteAddr() net.Addr               { return streamAddr(s.target) }
+func (s *Stream) SetDeadline(t time.Time) error      { return nil }
+func (s *Stream) SetReadDeadline(t time.Time) error  { return nil }
+func (s *Stream) SetWriteDeadline(t time.Time) error { return nil }
+
+type streamAddr string
+
+func (a streamAddr) Network() string { return "egress" }
+func (a streamAddr) String() string  { return string(a) }
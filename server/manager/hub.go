@@ -0,0 +1,352 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juliotorresmoreno/lipstick/helper"
+	"github.com/juliotorresmoreno/lipstick/server/common"
+	"github.com/juliotorresmoreno/lipstick/server/egress"
+	"github.com/juliotorresmoreno/lipstick/server/traffic"
+)
+
+// healthProbeInterval is how often a registered muxSession is pinged to
+// catch a connection that's gone half-open — dead without either side's
+// read ever failing. healthProbeTimeout bounds how long a single probe
+// waits for the reply before treating the session as dead.
+const (
+	healthProbeInterval = 30 * time.Second
+	healthProbeTimeout  = 5 * time.Second
+)
+
+// Agent is anything capable of answering a ticket issued by a NetworkHub
+// with a fresh connection back to the tunnel client. It exists for
+// backends that can't offer a muxSession — an SSH reverse-tunnel agent
+// (see server/ssh) answers a ticket by opening a forwarded-tcpip channel on
+// its own already-multiplexed SSH connection. A hub with at least one
+// registered muxSession prefers it over the ticket path; see dispatch.
+//
+// NotifyTicket may answer asynchronously (as server/ssh's domainAgent
+// does, to avoid deadlocking the hub's own select loop): if it can't
+// deliver a connection for ticket, it must call onFailure exactly once so
+// the hub can evict the pending ticket and close the client it was issued
+// for, instead of leaking both.
+type Agent interface {
+	NotifyTicket(ticket string, onFailure func(ticket string)) error
+	Close() error
+}
+
+// muxSession is one agent's multiplexed connection (see server/egress),
+// tracked alongside how many streams it's currently carrying so dispatch
+// can pick the least-loaded one. One domain can have several, e.g. while
+// an agent is reconnecting, or when it runs multiple processes for HA.
+type muxSession struct {
+	session *egress.Session
+	active  int32
+}
+
+// streamHeader is the payload carried by the stream-open frame
+// egress.Session.Open sends before any client bytes. It lets the agent log or route on the
+// ticket/client IP/domain without a round trip back through the hub —
+// the bottleneck this type replaces (see fulfil/dispatchTicket).
+type streamHeader struct {
+	Ticket   string `json:"ticket"`
+	ClientIP string `json:"clientIP"`
+	Domain   string `json:"domain"`
+}
+
+// NetworkHub owns every agent registered for a single domain and pairs
+// incoming public requests with a fresh tunnel back to that domain's
+// agent. A WebSocket-backed agent registers a muxSession, letting one
+// TCP/TLS connection carry many concurrent requests as logical streams; a
+// ticket-based Agent (server/ssh) still round-trips through
+// serverRequests, since an SSH connection is already its own multiplexed
+// transport.
+type NetworkHub struct {
+	domain             string
+	registerSession    chan *egress.Session
+	registerAgent      chan Agent
+	unregisterAgent    chan Agent
+	serverRequests     chan *request
+	ticketFailed       chan string
+	sessionDead        chan *muxSession
+	incomingClientConn chan *common.RemoteConn
+	shutdownSignal     chan struct{}
+	stopped            chan struct{}
+	unregisterDomain   chan<- string
+	trafficManager     *traffic.TrafficManager
+	statsRequest       chan chan Stats
+
+	sessions []*muxSession
+	agents   []Agent
+	pending  map[string]*common.RemoteConn
+	next     int
+}
+
+// Stats is a point-in-time view of a hub, used by admin/monitoring
+// endpoints.
+type Stats struct {
+	Domain     string
+	AgentCount int
+	Pending    int
+}
+
+// NewNetworkHub builds a hub for domain. unregisterDomain is stored on the
+// hub but never sent on: the owning Manager drops the hub from
+// Manager.hubs itself when it shuts the hub down.
+func NewNetworkHub(domain string, unregisterDomain chan<- string, trafficManager *traffic.TrafficManager, bufferSize int) *NetworkHub {
+	return &NetworkHub{
+		domain:             domain,
+		registerSession:    make(chan *egress.Session),
+		registerAgent:      make(chan Agent),
+		unregisterAgent:    make(chan Agent),
+		serverRequests:     make(chan *request),
+		ticketFailed:       make(chan string),
+		sessionDead:        make(chan *muxSession),
+		incomingClientConn: make(chan *common.RemoteConn),
+		shutdownSignal:     make(chan struct{}),
+		stopped:            make(chan struct{}),
+		unregisterDomain:   unregisterDomain,
+		trafficManager:     trafficManager,
+		statsRequest:       make(chan chan Stats),
+		pending:            make(map[string]*common.RemoteConn),
+	}
+}
+
+func (hub *NetworkHub) listen() {
+	for {
+		select {
+		case session := <-hub.registerSession:
+			mux := &muxSession{session: session}
+			hub.sessions = append(hub.sessions, mux)
+			go hub.monitorSession(mux)
+		case agent := <-hub.registerAgent:
+			hub.agents = append(hub.agents, agent)
+		case agent := <-hub.unregisterAgent:
+			for i, a := range hub.agents {
+				if a == agent {
+					hub.agents = append(hub.agents[:i], hub.agents[i+1:]...)
+					break
+				}
+			}
+		case client := <-hub.incomingClientConn:
+			hub.dispatch(client)
+		case req := <-hub.serverRequests:
+			hub.fulfil(req)
+		case ticket := <-hub.ticketFailed:
+			if client, ok := hub.pending[ticket]; ok {
+				delete(hub.pending, ticket)
+				client.Close()
+			}
+		case mux := <-hub.sessionDead:
+			hub.removeSession(mux)
+		case reply := <-hub.statsRequest:
+			reply <- Stats{
+				Domain:     hub.domain,
+				AgentCount: len(hub.sessions) + len(hub.agents),
+				Pending:    len(hub.pending),
+			}
+		case <-hub.shutdownSignal:
+			hub.close()
+			return
+		}
+	}
+}
+
+// Stats returns a point-in-time snapshot of the hub. Safe to call from any
+// goroutine: it's answered from inside hub.listen(), the only goroutine
+// that touches hub.sessions, hub.agents and hub.pending.
+func (hub *NetworkHub) Stats() Stats {
+	reply := make(chan Stats)
+	hub.statsRequest <- reply
+	return <-reply
+}
+
+// dispatch hands client to whichever backend is registered for the
+// domain, preferring a muxSession: opening a logical stream on it is a
+// single round trip, unlike the ticket path's notify-then-wait-for-an-
+// agent-initiated-connection dance.
+func (hub *NetworkHub) dispatch(client *common.RemoteConn) {
+	if len(hub.sessions) > 0 {
+		hub.dispatchSession(client)
+		return
+	}
+	hub.dispatchTicket(client)
+}
+
+// dispatchSession opens a stream on the least-loaded registered session
+// and pipes client's bytes over it. The stream carries a small header
+// identifying the request before any client data, so the agent doesn't
+// need to round-trip back through the hub to learn who it's serving. A
+// session whose Open fails is dead — e.g. its connection dropped without
+// readLoop noticing yet — so it's evicted on the spot and the next
+// least-loaded survivor is tried instead of failing the client outright.
+// If every session turns out to be dead, it falls back to the ticket path
+// so a domain with both kinds of agent registered still gets served.
+func (hub *NetworkHub) dispatchSession(client *common.RemoteConn) {
+	for len(hub.sessions) > 0 {
+		mux := hub.leastLoaded()
+
+		header, _ := json.Marshal(streamHeader{
+			Ticket:   uuid.NewString(),
+			ClientIP: client.RemoteAddr().String(),
+			Domain:   hub.domain,
+		})
+
+		stream, err := mux.session.Open(string(header))
+		if err != nil {
+			fmt.Println("Unable to open stream for", hub.domain, err)
+			hub.removeSession(mux)
+			continue
+		}
+
+		atomic.AddInt32(&mux.active, 1)
+		go hub.pipeSession(mux, client, stream)
+		return
+	}
+
+	hub.dispatchTicket(client)
+}
+
+// removeSession drops dead from hub.sessions, e.g. once dispatchSession's
+// Open has failed against it or monitorSession's health probe has timed
+// out, so leastLoaded stops steering new requests at a connection that's
+// no longer there.
+func (hub *NetworkHub) removeSession(dead *muxSession) {
+	for i, mux := range hub.sessions {
+		if mux == dead {
+			hub.sessions = append(hub.sessions[:i], hub.sessions[i+1:]...)
+			return
+		}
+	}
+}
+
+// monitorSession periodically pings mux's session so a half-open
+// connection — one that's gone dead without ever failing a read — is
+// evicted before leastLoaded keeps choosing it forever. It reports the
+// failure through hub.sessionDead rather than touching hub.sessions
+// directly, since it runs on its own goroutine rather than hub.listen()'s.
+// It exits once the session is reported dead or closes on its own; the
+// select against hub.stopped keeps that report from blocking forever if
+// the hub has already shut down and nothing reads hub.sessionDead anymore.
+func (hub *NetworkHub) monitorSession(mux *muxSession) {
+	ticker := time.NewTicker(healthProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := mux.session.Ping(healthProbeTimeout); err != nil {
+				select {
+				case hub.sessionDead <- mux:
+				case <-hub.stopped:
+				}
+				return
+			}
+		case <-mux.session.Done():
+			return
+		case <-hub.stopped:
+			return
+		}
+	}
+}
+
+func (hub *NetworkHub) pipeSession(mux *muxSession, client *common.RemoteConn, stream *egress.Stream) {
+	defer atomic.AddInt32(&mux.active, -1)
+
+	clientConn := hub.trafficManager.Wrap(hub.domain, client)
+	streamConn := hub.trafficManager.Wrap(hub.domain, stream)
+	defer clientConn.Close()
+	defer streamConn.Close()
+
+	go helper.Copy(streamConn, clientConn)
+	helper.Copy(clientConn, streamConn)
+}
+
+// leastLoaded returns the registered session currently carrying the
+// fewest open streams.
+func (hub *NetworkHub) leastLoaded() *muxSession {
+	best := hub.sessions[0]
+	bestLoad := atomic.LoadInt32(&best.active)
+	for _, mux := range hub.sessions[1:] {
+		if load := atomic.LoadInt32(&mux.active); load < bestLoad {
+			best, bestLoad = mux, load
+		}
+	}
+	return best
+}
+
+// dispatchTicket hands client a ticket via the next registered Agent,
+// round robin, and remembers it until that agent answers on
+// serverRequests. This is the path server/ssh's domainAgent uses.
+func (hub *NetworkHub) dispatchTicket(client *common.RemoteConn) {
+	if len(hub.agents) == 0 {
+		client.Close()
+		return
+	}
+
+	agent := hub.agents[hub.next%len(hub.agents)]
+	hub.next++
+
+	ticket := uuid.NewString()
+	hub.pending[ticket] = client
+
+	if err := agent.NotifyTicket(ticket, hub.failTicket); err != nil {
+		fmt.Println("Unable to notify ticket for", hub.domain, err)
+		delete(hub.pending, ticket)
+		client.Close()
+	}
+}
+
+// failTicket reports that an Agent was unable to deliver a connection for
+// ticket. It's the onFailure callback NotifyTicket implementations call,
+// possibly from a goroutine other than hub.listen(), so it hands off
+// through a channel rather than touching hub.pending directly. The select
+// against hub.stopped matches monitorSession: without it, a report that
+// arrives after hub.listen() has already returned would block the calling
+// goroutine (server/ssh's deliver) forever, since nothing reads
+// hub.ticketFailed anymore.
+func (hub *NetworkHub) failTicket(ticket string) {
+	select {
+	case hub.ticketFailed <- ticket:
+	case <-hub.stopped:
+	}
+}
+
+// fulfil pairs an agent-side connection answering ticket with the client
+// it was issued for and starts copying bytes between them.
+func (hub *NetworkHub) fulfil(req *request) {
+	client, ok := hub.pending[req.ticket]
+	if !ok {
+		req.conn.Close()
+		return
+	}
+	delete(hub.pending, req.ticket)
+
+	agentConn := hub.trafficManager.Wrap(hub.domain, req.conn)
+	clientConn := hub.trafficManager.Wrap(hub.domain, client)
+
+	go func() {
+		defer agentConn.Close()
+		defer clientConn.Close()
+
+		go helper.Copy(agentConn, clientConn)
+		helper.Copy(clientConn, agentConn)
+	}()
+}
+
+func (hub *NetworkHub) close() {
+	close(hub.stopped)
+	for _, mux := range hub.sessions {
+		mux.session.Close()
+	}
+	for _, agent := range hub.agents {
+		agent.Close()
+	}
+	for _, client := range hub.pending {
+		client.Close()
+	}
+}
@@ -6,12 +6,14 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"strconv"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/juliotorresmoreno/lipstick/helper"
 	"github.com/juliotorresmoreno/lipstick/server/auth"
 	"github.com/juliotorresmoreno/lipstick/server/common"
+	"github.com/juliotorresmoreno/lipstick/server/egress"
 	"github.com/juliotorresmoreno/lipstick/server/proxy"
 	"github.com/juliotorresmoreno/lipstick/server/traffic"
 )
@@ -44,40 +46,105 @@ var badGatewayContent = `<!DOCTYPE html>
 
 var badGatewayResponse = badGatewayHeader + fmt.Sprint(len(badGatewayContent)) + "\n\n" + badGatewayContent
 
-type websocketConn struct {
-	Domain                   string
-	AllowMultipleConnections bool
-	*websocket.Conn
+// domainSession claims a domain for a freshly registered agent session,
+// see server/egress. One domain can have several; each request goes to the
+// least-loaded one.
+type domainSession struct {
+	Domain  string
+	Session *egress.Session
 }
 
 type Manager struct {
-	engine           *gin.Engine
-	hubs             map[string]*NetworkHub
-	remoteConn       chan *common.RemoteConn
-	registerDomain   chan *websocketConn
-	unregisterDomain chan string
-	proxy            *proxy.Proxy
-	trafficManager   *traffic.TrafficManager
-	authManager      auth.AuthManager
-	addr             string
-	cert             string
-	key              string
-}
-
-func SetupManager(proxy *proxy.Proxy, addr string, cert string, key string) *Manager {
+	engine            *gin.Engine
+	hubs              map[string]*NetworkHub
+	remoteConn        chan *common.RemoteConn
+	registerSession   chan *domainSession
+	registerAgentCh   chan *agentRegistration
+	unregisterAgentCh chan *agentRegistration
+	fulfilTicketCh    chan *TicketFulfillment
+	snapshotReq       chan chan []HubSnapshot
+	disconnectReq     chan *disconnectRequest
+	unregisterDomain  chan string
+	registerEgressCh  chan *egress.Session
+	egressDialCh      chan *egressDialRequest
+	egressSessions    []*egress.Session
+	egressNext        int
+	proxy             *proxy.Proxy
+	trafficManager    *traffic.TrafficManager
+	authManagerMu     sync.RWMutex
+	authManager       auth.AuthManager // interface; see server/auth. Guarded by authManagerMu since SetAuthManager lets it be swapped while the manager is serving.
+	addr              string
+	cert              string
+	key               string
+}
+
+// egressDialRequest asks manage() to open a stream to target ("host:port")
+// on the next registered egress agent, round robin.
+type egressDialRequest struct {
+	Target string
+	Result chan *egressDialResult
+}
+
+type egressDialResult struct {
+	Conn net.Conn
+	Err  error
+}
+
+// agentRegistration claims a domain for an Agent that isn't a WebSocket
+// connection, such as an SSH reverse-tunnel client (see server/ssh).
+type agentRegistration struct {
+	Domain string
+	Agent  Agent
+}
+
+// TicketFulfillment delivers a fresh agent-side connection for a ticket
+// previously handed out by a hub's ticket path, completing a tunnel
+// request. Ticket-based agents (server/ssh) call Manager.FulfilTicket
+// directly; mux-session agents skip tickets entirely (see
+// NetworkHub.dispatchSession).
+type TicketFulfillment struct {
+	Domain string
+	Ticket string
+	Conn   net.Conn
+}
+
+// HubSnapshot is a point-in-time, read-only view of one domain's hub, used
+// by server/admin.
+type HubSnapshot struct {
+	Domain     string
+	AgentCount int
+	Pending    int
+}
+
+type disconnectRequest struct {
+	Domain string
+	Result chan bool
+}
+
+// SetupManager builds a Manager. authManager decides which agents are
+// allowed to claim which domains; callers typically build it from Config
+// (a file, http, or oidc backend — see server/auth).
+func SetupManager(proxy *proxy.Proxy, addr string, cert string, key string, authManager auth.AuthManager) *Manager {
 	gin.SetMode(gin.ReleaseMode)
 
 	manager := &Manager{
-		hubs:             make(map[string]*NetworkHub),
-		remoteConn:       make(chan *common.RemoteConn),
-		registerDomain:   make(chan *websocketConn),
-		unregisterDomain: make(chan string),
-		proxy:            proxy,
-		authManager:      auth.MakeAuthManager(),
-		trafficManager:   traffic.NewTrafficManager(64 * 1024),
-		addr:             addr,
-		cert:             cert,
-		key:              key,
+		hubs:              make(map[string]*NetworkHub),
+		remoteConn:        make(chan *common.RemoteConn),
+		registerSession:   make(chan *domainSession),
+		registerAgentCh:   make(chan *agentRegistration),
+		unregisterAgentCh: make(chan *agentRegistration),
+		fulfilTicketCh:    make(chan *TicketFulfillment),
+		snapshotReq:       make(chan chan []HubSnapshot),
+		disconnectReq:     make(chan *disconnectRequest),
+		unregisterDomain:  make(chan string),
+		registerEgressCh:  make(chan *egress.Session),
+		egressDialCh:      make(chan *egressDialRequest),
+		proxy:             proxy,
+		authManager:       authManager,
+		trafficManager:    traffic.NewTrafficManager(64 * 1024),
+		addr:              addr,
+		cert:              cert,
+		key:               key,
 	}
 
 	configureRouter(manager)
@@ -85,19 +152,123 @@ func SetupManager(proxy *proxy.Proxy, addr string, cert string, key string) *Man
 	return manager
 }
 
-func (manager *Manager) handleTunnel(conn net.Conn, ticket string) {
-	domainName, err := helper.GetDomainName(conn)
-	if err != nil {
-		log.Println("Unable to get domain name", err)
-		return
+// AuthManager exposes the manager's AuthManager so other subsystems (for
+// example server/ssh) can authorize registrations against the same domain
+// rules the WebSocket path uses.
+func (manager *Manager) AuthManager() auth.AuthManager {
+	manager.authManagerMu.RLock()
+	defer manager.authManagerMu.RUnlock()
+	return manager.authManager
+}
+
+// SetAuthManager swaps the manager's AuthManager at runtime, e.g. when
+// server/admin's PUT /api/config reconciles a reloaded config's auth
+// settings into the already-running Manager instead of requiring a
+// restart.
+func (manager *Manager) SetAuthManager(am auth.AuthManager) {
+	manager.authManagerMu.Lock()
+	defer manager.authManagerMu.Unlock()
+	manager.authManager = am
+}
+
+// RegisterAgent attaches a non-WebSocket tunnel backend to domain, creating
+// the domain's hub if this is the first agent to claim it.
+func (manager *Manager) RegisterAgent(domain string, agent Agent) {
+	manager.registerAgentCh <- &agentRegistration{Domain: domain, Agent: agent}
+}
+
+// UnregisterAgent drops agent from domain's hub, e.g. once its underlying
+// connection — an SSH reverse tunnel — has gone away, so the hub's round
+// robin stops selecting a forward that can no longer answer tickets.
+func (manager *Manager) UnregisterAgent(domain string, agent Agent) {
+	manager.unregisterAgentCh <- &agentRegistration{Domain: domain, Agent: agent}
+}
+
+// FulfilTicket delivers conn for ticket, see TicketFulfillment.
+func (manager *Manager) FulfilTicket(f *TicketFulfillment) {
+	manager.fulfilTicketCh <- f
+}
+
+// TrafficManager exposes the manager's TrafficManager so other subsystems
+// (for example server/admin) can read byte counters.
+func (manager *Manager) TrafficManager() *traffic.TrafficManager {
+	return manager.trafficManager
+}
+
+// HubsSnapshot returns a point-in-time view of every registered domain.
+// Safe to call from any goroutine: the snapshot is assembled inside
+// manage(), the only goroutine that mutates Manager.hubs.
+func (manager *Manager) HubsSnapshot() []HubSnapshot {
+	reply := make(chan []HubSnapshot)
+	manager.snapshotReq <- reply
+	return <-reply
+}
+
+// DisconnectDomain forcibly shuts down domain's hub, disconnecting its
+// agents. It reports whether domain was registered.
+func (manager *Manager) DisconnectDomain(domain string) bool {
+	result := make(chan bool)
+	manager.disconnectReq <- &disconnectRequest{Domain: domain, Result: result}
+	return <-result
+}
+
+// RegisterEgress adds an egress agent's multiplexed session to the pool
+// DialEgress dispatches outbound requests to, see server/egress.
+func (manager *Manager) RegisterEgress(session *egress.Session) {
+	manager.registerEgressCh <- session
+}
+
+// DialEgress opens a connection to host:port through a registered egress
+// agent, round robin. It implements proxy.EgressDialer, letting
+// ListenEgressProxy's HTTP CONNECT and SOCKS5 listeners dispatch through
+// the manager without server/proxy importing this package.
+func (manager *Manager) DialEgress(host string, port uint16) (net.Conn, error) {
+	result := make(chan *egressDialResult)
+	manager.egressDialCh <- &egressDialRequest{
+		Target: net.JoinHostPort(host, strconv.Itoa(int(port))),
+		Result: result,
 	}
+	r := <-result
+	return r.Conn, r.Err
+}
 
-	domain, ok := manager.hubs[domainName]
-	if !ok {
-		return
+// ListenEgressProxy starts the forward-proxy listeners that dispatch to
+// registered egress agents. Either address may be empty to skip that
+// listener.
+func (manager *Manager) ListenEgressProxy(httpConnectAddr, socks5Addr string) {
+	if httpConnectAddr != "" {
+		go func() {
+			if err := proxy.ListenHTTPConnect(httpConnectAddr, manager); err != nil {
+				log.Println("manager: http connect proxy failed", err)
+			}
+		}()
+	}
+	if socks5Addr != "" {
+		go func() {
+			if err := proxy.ListenSOCKS5(socks5Addr, manager); err != nil {
+				log.Println("manager: socks5 proxy failed", err)
+			}
+		}()
 	}
+}
+
+// dialEgress picks the next registered egress session, round robin,
+// dropping any session that's gone stale until one answers or none are
+// left. Only called from manage().
+func (manager *Manager) dialEgress(target string) *egressDialResult {
+	for len(manager.egressSessions) > 0 {
+		idx := manager.egressNext % len(manager.egressSessions)
+		session := manager.egressSessions[idx]
+		manager.egressNext++
 
-	domain.serverRequests <- &request{ticket: ticket, conn: conn}
+		stream, err := session.Open(target)
+		if err == nil {
+			return &egressDialResult{Conn: stream}
+		}
+
+		manager.egressSessions = append(manager.egressSessions[:idx], manager.egressSessions[idx+1:]...)
+	}
+	return &egressDialResult{Err: fmt.Errorf("manager: no egress agent available")}
 }
 
 func (manager *Manager) Listen() {
@@ -137,24 +308,69 @@ func (manager *Manager) manage(done chan struct{}) {
 	defer fmt.Println("Manager closed")
 	for {
 		select {
-		case conn := <-manager.registerDomain:
-			if manager.hubs[conn.Domain] == nil {
-				manager.hubs[conn.Domain] = NewNetworkHub(
-					conn.Domain,
+		case reg := <-manager.registerSession:
+			if manager.hubs[reg.Domain] == nil {
+				manager.hubs[reg.Domain] = NewNetworkHub(
+					reg.Domain,
+					manager.unregisterDomain,
+					manager.trafficManager,
+					64*1024,
+				)
+				go manager.hubs[reg.Domain].listen()
+			}
+			manager.hubs[reg.Domain].registerSession <- reg.Session
+			fmt.Println("Registered", reg.Domain)
+		case reg := <-manager.registerAgentCh:
+			if manager.hubs[reg.Domain] == nil {
+				manager.hubs[reg.Domain] = NewNetworkHub(
+					reg.Domain,
 					manager.unregisterDomain,
 					manager.trafficManager,
 					64*1024,
 				)
-				go manager.hubs[conn.Domain].listen()
+				go manager.hubs[reg.Domain].listen()
+			}
+			manager.hubs[reg.Domain].registerAgent <- reg.Agent
+			fmt.Println("Registered", reg.Domain, "via external agent")
+		case reg := <-manager.unregisterAgentCh:
+			if hub, ok := manager.hubs[reg.Domain]; ok {
+				hub.unregisterAgent <- reg.Agent
 			}
-			manager.hubs[conn.Domain].registerWebSocket <- conn
-			fmt.Println("Registered", conn.Domain)
+		case f := <-manager.fulfilTicketCh:
+			if hub, ok := manager.hubs[f.Domain]; ok {
+				hub.serverRequests <- &request{ticket: f.Ticket, conn: f.Conn}
+			} else {
+				f.Conn.Close()
+			}
+		case session := <-manager.registerEgressCh:
+			manager.egressSessions = append(manager.egressSessions, session)
+			fmt.Println("Registered egress agent")
+		case req := <-manager.egressDialCh:
+			req.Result <- manager.dialEgress(req.Target)
 		case domain := <-manager.unregisterDomain:
 			if manager.hubs[domain] != nil {
 				manager.hubs[domain].shutdownSignal <- struct{}{}
 				delete(manager.hubs, domain)
 			}
 			fmt.Println("Unregistered", domain)
+		case reply := <-manager.snapshotReq:
+			snapshots := make([]HubSnapshot, 0, len(manager.hubs))
+			for domain, hub := range manager.hubs {
+				stats := hub.Stats()
+				snapshots = append(snapshots, HubSnapshot{
+					Domain:     domain,
+					AgentCount: stats.AgentCount,
+					Pending:    stats.Pending,
+				})
+			}
+			reply <- snapshots
+		case req := <-manager.disconnectReq:
+			hub, ok := manager.hubs[req.Domain]
+			if ok {
+				hub.shutdownSignal <- struct{}{}
+				delete(manager.hubs, req.Domain)
+			}
+			req.Result <- ok
 		case remoteConn := <-manager.remoteConn:
 			if manager.hubs[remoteConn.Domain] == nil {
 				fmt.Fprint(remoteConn, badGatewayResponse)
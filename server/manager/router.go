@@ -0,0 +1,67 @@
+package manager
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juliotorresmoreno/lipstick/helper"
+	"github.com/juliotorresmoreno/lipstick/server/egress"
+)
+
+// egressDomain is the pseudo-domain an identity must be allowed to claim
+// to register as an egress agent over /ws/egress, reusing the same
+// domain-authorization model the reverse-tunnel path uses rather than
+// introducing a separate permission scheme.
+const egressDomain = "egress"
+
+// configureRouter wires the manager's gin engine: the WebSocket endpoint an
+// agent uses to claim a domain, and the WebSocket endpoint an egress agent
+// uses to offer outbound access. Every registration is authenticated
+// through manager.authManager and rejected outright if the claimed domain
+// isn't among the ones the resulting identity is allowed to claim —
+// there's no more implicit "any WebSocket wins".
+func configureRouter(manager *Manager) {
+	manager.engine = gin.New()
+
+	manager.engine.GET("/ws", func(c *gin.Context) {
+		domain := c.Query("domain")
+		if domain == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		identity, err := manager.AuthManager().Authenticate(c.Request.Context(), c.Request)
+		if err != nil || !identity.Allowed(domain) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			log.Println("Unable to upgrade connection", err)
+			return
+		}
+
+		manager.registerSession <- &domainSession{
+			Domain:  domain,
+			Session: egress.NewSession(helper.NewWebSocketIO(conn)),
+		}
+	})
+
+	manager.engine.GET("/ws/egress", func(c *gin.Context) {
+		identity, err := manager.AuthManager().Authenticate(c.Request.Context(), c.Request)
+		if err != nil || !identity.Allowed(egressDomain) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			log.Println("Unable to upgrade connection", err)
+			return
+		}
+
+		manager.RegisterEgress(egress.NewSession(helper.NewWebSocketIO(conn)))
+	})
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/juliotorresmoreno/lipstick/helper"
+)
+
+// EgressDialer opens a connection to a destination host:port through a
+// registered egress agent. manager.Manager satisfies this without proxy
+// needing to import the manager package.
+type EgressDialer interface {
+	DialEgress(host string, port uint16) (net.Conn, error)
+}
+
+// ListenHTTPConnect accepts HTTP CONNECT requests on addr and relays each
+// one through dialer. This turns an agent that published no domain into a
+// chisel-style forward proxy: it provides outbound access on the client's
+// behalf instead of exposing a local service.
+func ListenHTTPConnect(addr string, dialer EgressDialer) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("proxy: listen %s: %w", addr, err)
+	}
+	log.Println("Listening HTTP CONNECT proxy on", addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Println("proxy: connect accept error", err)
+			continue
+		}
+		go handleConnect(conn, dialer)
+	}
+}
+
+// bufferedConn is conn with its Read routed through reader instead of
+// straight to the socket. handleConnect parses the CONNECT request line by
+// line with a bufio.Reader, which can buffer client bytes past the blank
+// line ending the headers (e.g. the first bytes of the tunneled protocol,
+// sent in the same packet); reading the tunnel body off conn directly
+// would silently drop them, so it's read off bufferedConn instead.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
+
+func handleConnect(conn net.Conn, dialer EgressDialer) {
+	reader := bufio.NewReader(conn)
+	client := &bufferedConn{Conn: conn, reader: reader}
+
+	requestLine, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(requestLine, "CONNECT ") {
+		conn.Close()
+		return
+	}
+	fields := strings.Fields(requestLine)
+	if len(fields) < 2 {
+		conn.Close()
+		return
+	}
+	target := fields[1]
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil || line == "\r\n" || line == "\n" {
+			break
+		}
+	}
+
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		fmt.Fprint(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		conn.Close()
+		return
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		fmt.Fprint(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		conn.Close()
+		return
+	}
+
+	egressConn, err := dialer.DialEgress(host, uint16(port))
+	if err != nil {
+		fmt.Fprint(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		conn.Close()
+		return
+	}
+
+	fmt.Fprint(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+
+	go helper.Copy(egressConn, client)
+	helper.Copy(client, egressConn)
+}
@@ -0,0 +1,65 @@
+// Package proxy runs the public-facing listeners Lipstick accepts traffic
+// on: the domain-routed reverse proxy (Proxy) and, for agents that publish
+// outbound access instead of a domain, HTTP CONNECT and SOCKS5 forward
+// proxies (see connect.go, socks5.go).
+package proxy
+
+import (
+	"log"
+	"net"
+
+	"github.com/juliotorresmoreno/lipstick/helper"
+	"github.com/juliotorresmoreno/lipstick/server/common"
+)
+
+// Proxy is the public-facing listener for domain-based reverse proxying:
+// it accepts client connections, works out which domain each one is for,
+// and hands it to the manager over remoteConn.
+type Proxy struct {
+	addr     string
+	listener net.Listener
+}
+
+// NewProxy builds a Proxy bound to addr. It doesn't start listening until
+// Listen is called.
+func NewProxy(addr string) *Proxy {
+	return &Proxy{addr: addr}
+}
+
+// Listen accepts connections on addr until the listener is closed, sending
+// each one, tagged with its domain, to remoteConn.
+func (p *Proxy) Listen(remoteConn chan<- *common.RemoteConn) {
+	listener, err := net.Listen("tcp", p.addr)
+	if err != nil {
+		log.Println("proxy: unable to listen on", p.addr, err)
+		return
+	}
+	p.listener = listener
+	log.Println("Listening proxy on", p.addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Println("proxy: accept error", err)
+			return
+		}
+		go p.route(conn, remoteConn)
+	}
+}
+
+func (p *Proxy) route(conn net.Conn, remoteConn chan<- *common.RemoteConn) {
+	domain, err := helper.GetDomainName(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	remoteConn <- &common.RemoteConn{Conn: conn, Domain: domain}
+}
+
+// Close stops accepting new connections.
+func (p *Proxy) Close() error {
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Close()
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+
+	"github.com/juliotorresmoreno/lipstick/helper"
+)
+
+// ListenSOCKS5 accepts SOCKS5 connections on addr and relays each CONNECT
+// request through dialer, the same egress path ListenHTTPConnect uses.
+// Only the no-auth method and the CONNECT command are supported, which is
+// all an egress-mode agent needs.
+func ListenSOCKS5(addr string, dialer EgressDialer) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("proxy: listen %s: %w", addr, err)
+	}
+	log.Println("Listening SOCKS5 proxy on", addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Println("proxy: socks5 accept error", err)
+			continue
+		}
+		go handleSOCKS5(conn, dialer)
+	}
+}
+
+func handleSOCKS5(conn net.Conn, dialer EgressDialer) {
+	if !socks5Handshake(conn) {
+		conn.Close()
+		return
+	}
+
+	host, port, ok := socks5ReadRequest(conn)
+	if !ok {
+		conn.Close()
+		return
+	}
+
+	egressConn, err := dialer.DialEgress(host, port)
+	if err != nil {
+		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		conn.Close()
+		return
+	}
+
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+
+	go helper.Copy(egressConn, conn)
+	helper.Copy(conn, egressConn)
+}
+
+// socks5Handshake reads the client's greeting and replies that no
+// authentication is required.
+func socks5Handshake(conn net.Conn) bool {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != 0x05 {
+		return false
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return false
+	}
+	_, err := conn.Write([]byte{0x05, 0x00})
+	return err == nil
+}
+
+// socks5ReadRequest reads a SOCKS5 request and returns the requested
+// destination. It rejects anything but the CONNECT command.
+func socks5ReadRequest(conn net.Conn) (string, uint16, bool) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil || header[1] != 0x01 {
+		return "", 0, false
+	}
+
+	var host string
+	switch header[3] {
+	case 0x01: // IPv4
+		addr := make([]byte, 4)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", 0, false
+		}
+		host = net.IP(addr).String()
+	case 0x03: // domain name
+		lenBuf := make([]byte, 1)
+		if _, err := io.ReadFull(conn, lenBuf); err != nil {
+			return "", 0, false
+		}
+		domain := make([]byte, lenBuf[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", 0, false
+		}
+		host = string(domain)
+	case 0x04: // IPv6
+		addr := make([]byte, 16)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", 0, false
+		}
+		host = net.IP(addr).String()
+	default:
+		return "", 0, false
+	}
+
+	portBuf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, portBuf); err != nil {
+		return "", 0, false
+	}
+	return host, binary.BigEndian.Uint16(portBuf), true
+}
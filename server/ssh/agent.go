@@ -0,0 +1,80 @@
+package ssh
+
+import (
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/juliotorresmoreno/lipstick/server/manager"
+	"golang.org/x/crypto/ssh"
+)
+
+// domainAgent adapts one SSH reverse-tunnel forward to manager.Agent. An
+// SSH connection is already its own multiplexed transport, so unlike a
+// WebSocket agent it has no need for a muxSession: it answers a ticket by
+// opening a forwarded-tcpip channel back to the client right away.
+type domainAgent struct {
+	domain     string
+	originPort uint32
+	conn       *ssh.ServerConn
+	manager    *manager.Manager
+}
+
+// forwardedTCPIPPayload is the RFC 4254 §7.2 payload for a "forwarded-tcpip"
+// channel open.
+type forwardedTCPIPPayload struct {
+	Address       string
+	Port          uint32
+	OriginAddress string
+	OriginPort    uint32
+}
+
+// NotifyTicket implements manager.Agent. It returns immediately and opens
+// the channel in the background: the hub that called it is the same
+// goroutine that will later receive the fulfillment on its serverRequests
+// channel, so blocking here would deadlock. If the channel open fails —
+// the SSH connection is dead or dying — onFailure is called so the hub
+// can evict the pending ticket and close the client it was issued for
+// instead of leaking both.
+func (a *domainAgent) NotifyTicket(ticket string, onFailure func(ticket string)) error {
+	go a.deliver(ticket, onFailure)
+	return nil
+}
+
+func (a *domainAgent) deliver(ticket string, onFailure func(ticket string)) {
+	originHost, originPortStr, err := net.SplitHostPort(a.conn.RemoteAddr().String())
+	if err != nil {
+		originHost = a.conn.RemoteAddr().String()
+	}
+	originPort, _ := strconv.ParseUint(originPortStr, 10, 32)
+
+	payload := forwardedTCPIPPayload{
+		Address:       a.domain,
+		Port:          a.originPort,
+		OriginAddress: originHost,
+		OriginPort:    uint32(originPort),
+	}
+
+	channel, reqs, err := a.conn.OpenChannel("forwarded-tcpip", ssh.Marshal(payload))
+	if err != nil {
+		log.Println("ssh: unable to open forwarded-tcpip channel for", a.domain, err)
+		onFailure(ticket)
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	a.manager.FulfilTicket(&manager.TicketFulfillment{
+		Domain: a.domain,
+		Ticket: ticket,
+		Conn:   newChannelConn(channel, a.conn),
+	})
+}
+
+// Close implements manager.Agent. The underlying SSH connection is owned
+// by handleConn, so there's nothing to release here — callers are
+// expected to also call Manager.UnregisterAgent (see
+// server.handleGlobalRequests/handleCancelForward) so the hub's round
+// robin stops selecting a forward that's gone.
+func (a *domainAgent) Close() error {
+	return nil
+}
@@ -0,0 +1,30 @@
+package ssh
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// channelConn adapts an ssh.Channel to the net.Conn shape NetworkHub
+// expects on its serverRequests feed, so the rest of the tunneling pipeline
+// doesn't need to know whether a connection came from a WebSocket agent or
+// an SSH reverse tunnel.
+type channelConn struct {
+	ssh.Channel
+	sshConn ssh.Conn
+}
+
+func newChannelConn(channel ssh.Channel, sshConn ssh.Conn) net.Conn {
+	return &channelConn{Channel: channel, sshConn: sshConn}
+}
+
+func (c *channelConn) LocalAddr() net.Addr  { return c.sshConn.LocalAddr() }
+func (c *channelConn) RemoteAddr() net.Addr { return c.sshConn.RemoteAddr() }
+
+// SSH channels have no notion of I/O deadlines; these are no-ops so
+// channelConn still satisfies net.Conn.
+func (c *channelConn) SetDeadline(t time.Time) error      { return nil }
+func (c *channelConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *channelConn) SetWriteDeadline(t time.Time) error { return nil }
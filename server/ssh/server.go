@@ -0,0 +1,193 @@
+// Package ssh lets a domain owner publish a local service by opening a
+// plain SSH reverse tunnel (`ssh -R domain:0:localhost:3000
+// tunnel@lipstick`), with no Lipstick-specific client binary required. It
+// registers a manager.Agent per forwarded domain so the rest of the
+// tunneling pipeline — domain routing, TLS termination, traffic accounting
+// — behaves exactly as it does for a WebSocket agent.
+package ssh
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+
+	"github.com/juliotorresmoreno/lipstick/server/auth"
+	"github.com/juliotorresmoreno/lipstick/server/manager"
+	"golang.org/x/crypto/ssh"
+)
+
+// nextEphemeralPort hands out synthetic port numbers for forwards that
+// ask for one (ssh -R domain:0:...), per RFC 4254 §7.1: the server must
+// allocate and report a real port in its reply rather than echoing back
+// the client's 0. Lipstick routes by domain, not by port, so there's no
+// actual listening socket to allocate — this counter just gives SSH
+// clients a stable, non-zero port to log instead of a protocol-violating
+// echo of 0.
+var nextEphemeralPort uint32 = 20000
+
+func allocatePort(requested uint32) uint32 {
+	if requested != 0 {
+		return requested
+	}
+	return atomic.AddUint32(&nextEphemeralPort, 1)
+}
+
+// Server accepts SSH reverse-tunnel clients and registers each forwarded
+// domain with a manager.Manager.
+type Server struct {
+	manager *manager.Manager
+	config  *ssh.ServerConfig
+	addr    string
+}
+
+// NewServer builds a Server listening on addr. hostKey is the server's own
+// SSH host key, used to prove its identity to connecting agents. Clients
+// are admitted at the transport level; a forwarded domain is only accepted
+// once its public key is authorized for that domain in mgr.AuthManager().
+func NewServer(mgr *manager.Manager, addr string, hostKey ssh.Signer) *Server {
+	server := &Server{manager: mgr, addr: addr}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: server.publicKeyCallback,
+	}
+	config.AddHostKey(hostKey)
+	server.config = config
+
+	return server
+}
+
+// Listen accepts SSH connections on addr until the listener fails.
+func (server *Server) Listen() error {
+	listener, err := net.Listen("tcp", server.addr)
+	if err != nil {
+		return fmt.Errorf("ssh: listen %s: %w", server.addr, err)
+	}
+	log.Println("Listening ssh tunnels on", server.addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Println("ssh: accept error", err)
+			continue
+		}
+		go server.handleConn(conn)
+	}
+}
+
+// publicKeyCallback stashes the presented key on the connection's
+// Permissions so handleTCPIPForward can check it against the domain the
+// client later asks to forward; the domain isn't known yet at this point.
+func (server *Server) publicKeyCallback(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	return &ssh.Permissions{
+		Extensions: map[string]string{"pubkey": string(key.Marshal())},
+	}, nil
+}
+
+func (server *Server) handleConn(raw net.Conn) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(raw, server.config)
+	if err != nil {
+		log.Println("ssh: handshake failed", err)
+		raw.Close()
+		return
+	}
+	defer sshConn.Close()
+
+	// Agents never need to open channels themselves; every channel this
+	// connection carries is one we open in response to an incoming client.
+	go rejectChannels(chans)
+
+	server.handleGlobalRequests(sshConn, reqs)
+}
+
+func rejectChannels(chans <-chan ssh.NewChannel) {
+	for newChannel := range chans {
+		newChannel.Reject(ssh.UnknownChannelType, "lipstick only opens channels it initiates")
+	}
+}
+
+func (server *Server) handleGlobalRequests(sshConn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	bound := map[string]*domainAgent{}
+
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward":
+			server.handleTCPIPForward(sshConn, req, bound)
+		case "cancel-tcpip-forward":
+			server.handleCancelForward(sshConn, req, bound)
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+
+	for domain, agent := range bound {
+		server.manager.UnregisterAgent(domain, agent)
+		agent.Close()
+	}
+}
+
+type tcpipForwardPayload struct {
+	Address string
+	Port    uint32
+}
+
+func (server *Server) handleTCPIPForward(sshConn *ssh.ServerConn, req *ssh.Request, bound map[string]*domainAgent) {
+	var payload tcpipForwardPayload
+	if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Address == "" {
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+		return
+	}
+
+	domain := payload.Address
+
+	keyAuth, ok := server.manager.AuthManager().(auth.KeyAuthorizer)
+	if !ok {
+		log.Println("ssh: configured AuthManager can't authorize SSH public keys")
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+		return
+	}
+
+	key, err := ssh.ParsePublicKey([]byte(sshConn.Permissions.Extensions["pubkey"]))
+	if err != nil || !keyAuth.AuthorizedKey(domain, key) {
+		log.Println("ssh: rejected forward for unauthorized domain", domain)
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+		return
+	}
+
+	port := allocatePort(payload.Port)
+
+	agent := &domainAgent{
+		domain:     domain,
+		originPort: port,
+		conn:       sshConn,
+		manager:    server.manager,
+	}
+	bound[domain] = agent
+	server.manager.RegisterAgent(domain, agent)
+
+	if req.WantReply {
+		req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+	}
+}
+
+func (server *Server) handleCancelForward(sshConn *ssh.ServerConn, req *ssh.Request, bound map[string]*domainAgent) {
+	var payload tcpipForwardPayload
+	if err := ssh.Unmarshal(req.Payload, &payload); err == nil {
+		if agent, ok := bound[payload.Address]; ok {
+			server.manager.UnregisterAgent(payload.Address, agent)
+			agent.Close()
+			delete(bound, payload.Address)
+		}
+	}
+	if req.WantReply {
+		req.Reply(true, nil)
+	}
+}
@@ -0,0 +1,103 @@
+package traffic
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter tracks bytes copied between a client and an agent for a single
+// domain.
+type Counter struct {
+	BytesIn  int64
+	BytesOut int64
+}
+
+// TrafficManager accounts bytes flowing through tunneled connections on a
+// per-domain basis.
+type TrafficManager struct {
+	bufferSize int
+
+	mu       sync.RWMutex
+	counters map[string]*Counter
+}
+
+// NewTrafficManager builds a TrafficManager. bufferSize is the copy buffer
+// size hubs should use when piping bytes between a client and an agent.
+func NewTrafficManager(bufferSize int) *TrafficManager {
+	return &TrafficManager{
+		bufferSize: bufferSize,
+		counters:   make(map[string]*Counter),
+	}
+}
+
+// BufferSize returns the copy buffer size hubs should use.
+func (tm *TrafficManager) BufferSize() int {
+	return tm.bufferSize
+}
+
+func (tm *TrafficManager) counter(domain string) *Counter {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	c, ok := tm.counters[domain]
+	if !ok {
+		c = &Counter{}
+		tm.counters[domain] = c
+	}
+	return c
+}
+
+// Wrap returns conn instrumented to add to domain's byte counters as data
+// flows through it.
+func (tm *TrafficManager) Wrap(domain string, conn net.Conn) net.Conn {
+	return &countingConn{Conn: conn, counter: tm.counter(domain)}
+}
+
+// Snapshot returns a point-in-time copy of domain's byte counters. It
+// returns the zero Counter for a domain that hasn't carried any traffic
+// yet.
+func (tm *TrafficManager) Snapshot(domain string) Counter {
+	tm.mu.RLock()
+	c, ok := tm.counters[domain]
+	tm.mu.RUnlock()
+	if !ok {
+		return Counter{}
+	}
+	return Counter{
+		BytesIn:  atomic.LoadInt64(&c.BytesIn),
+		BytesOut: atomic.LoadInt64(&c.BytesOut),
+	}
+}
+
+// All returns a point-in-time copy of every domain's byte counters.
+func (tm *TrafficManager) All() map[string]Counter {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	out := make(map[string]Counter, len(tm.counters))
+	for domain, c := range tm.counters {
+		out[domain] = Counter{
+			BytesIn:  atomic.LoadInt64(&c.BytesIn),
+			BytesOut: atomic.LoadInt64(&c.BytesOut),
+		}
+	}
+	return out
+}
+
+type countingConn struct {
+	net.Conn
+	counter *Counter
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.counter.BytesIn, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.counter.BytesOut, int64(n))
+	return n, err
+}